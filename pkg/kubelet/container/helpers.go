@@ -0,0 +1,76 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/third_party/forked/golang/expansion"
+)
+
+// EnvVar is a name/value pair after every EnvVarSource (a literal Value,
+// ValueFrom.FieldRef such as metadata.annotations[...] or status.podIP,
+// ValueFrom.ResourceFieldRef such as limits.cpu, ...) has already been
+// resolved to a final string. Building this list is the kubelet pod env-var
+// builder's job; the functions below only ever see resolved pairs and have
+// no knowledge of v1.EnvVarSource, so they cannot be handed a raw
+// v1.EnvVar whose Value is empty because ValueFrom was set instead.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ExpandContainerCommandAndArgs expands the $(VAR) references in a
+// container's Command and Args using the values of envs, returning new
+// slices with the expansions applied. Unresolved references are passed
+// through to expansion.Expand verbatim.
+func ExpandContainerCommandAndArgs(container *v1.Container, envs []EnvVar) (command []string, args []string) {
+	mapping := expansion.MappingFuncFor(envVarsToMap(envs))
+
+	if len(container.Command) != 0 {
+		for _, cmd := range container.Command {
+			command = append(command, expansion.Expand(cmd, mapping))
+		}
+	}
+
+	if len(container.Args) != 0 {
+		for _, arg := range container.Args {
+			args = append(args, expansion.Expand(arg, mapping))
+		}
+	}
+
+	return command, args
+}
+
+// ExpandContainerVolumeMounts expands the $(VAR) references in a volume
+// mount's SubPathExpr using the values of envs. Because envs is already
+// resolved, any EnvVarSource usable as a literal env value (annotations,
+// resourceFieldRef, podIP/hostIP, ...) is usable in SubPathExpr the same
+// way, with no special-casing required here.
+func ExpandContainerVolumeMounts(mount v1.VolumeMount, envs []EnvVar) (string, error) {
+	mapping := expansion.MappingFuncFor(envVarsToMap(envs))
+	return expansion.Expand(mount.SubPathExpr, mapping), nil
+}
+
+// envVarsToMap constructs a map of env name to value from a resolved env
+// list, for use as expansion.MappingFuncFor's context.
+func envVarsToMap(envs []EnvVar) map[string]string {
+	result := make(map[string]string, len(envs))
+	for _, env := range envs {
+		result[env.Name] = env.Value
+	}
+	return result
+}
@@ -17,19 +17,32 @@ limitations under the License.
 package common
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+	e2eskipper "k8s.io/kubernetes/test/e2e/framework/skipper"
 	imageutils "k8s.io/kubernetes/test/utils/image"
 
 	"github.com/onsi/ginkgo"
+	"github.com/prometheus/common/expfmt"
+)
+
+// These are the API server feature gates whose state gatekeeps the
+// expansion-related conformance behaviors exercised below. The names match
+// the constants defined in k8s.io/apiserver/pkg/util/feature.
+const (
+	subpathEnvExpansionGate = "VolumeSubpathEnvExpansion"
+	envVarExpansionGate     = "EnvVarExpansion"
 )
 
 // These tests exercise the Kubernetes expansion syntax $(VAR).
@@ -465,6 +478,585 @@ var _ = framework.KubeDescribe("Variable Expansion", func() {
 			framework.Failf("expected to be able to verify new file does not exist")
 		}
 	})
+
+	/*
+		Release : v1.21
+		Testname: VolumeSubpathEnvExpansion, subpath from resource limits and pod IP
+		Description: Mirroring the existing support for expanding
+		metadata.annotations[...] into a subpath, a container's SubPathExpr MUST
+		also be expandable from valueFrom.resourceFieldRef (e.g. limits.memory,
+		requests.cpu) and from status.podIP/status.hostIP. The kubelet's pod
+		env-var builder is responsible for resolving these EnvVarSource values
+		to plain strings before subpath expansion ever runs (see
+		pkg/kubelet/container.EnvVar); this test only observes the result
+		through the container's mounted filesystem.
+	*/
+	framework.ConformanceIt("should allow substituting values in a volume subpath from resource limits and pod IP [sig-storage]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name: "CPU_LIMIT",
+				ValueFrom: &v1.EnvVarSource{
+					ResourceFieldRef: &v1.ResourceFieldSelector{
+						Resource: "limits.cpu",
+						Divisor:  resource.MustParse("1m"),
+					},
+				},
+			},
+			{
+				Name: "POD_IP",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{
+						FieldPath: "status.podIP",
+					},
+				},
+			},
+		}
+		mounts := []v1.VolumeMount{
+			{
+				Name:        "workdir1",
+				MountPath:   "/subpath_mount",
+				SubPathExpr: "$(CPU_LIMIT)/$(POD_IP)",
+			},
+			{
+				Name:      "workdir1",
+				MountPath: "/volume_mount",
+			},
+		}
+		volumes := []v1.Volume{
+			{
+				Name: "workdir1",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		// The container creates the liveness probe's target file itself on
+		// every start, so the probe passes until waitForPodContainerRestart
+		// removes the file.
+		pod := newPod([]string{"/bin/sh", "-ec", "touch /subpath_mount/test.log; sleep 100000"}, envVars, mounts, volumes)
+		pod.Spec.RestartPolicy = v1.RestartPolicyOnFailure
+		pod.Spec.Containers[0].Resources = v1.ResourceRequirements{
+			Limits: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("100m"),
+			},
+		}
+		// CPU limits and pod IP are both immutable on a running pod outside
+		// of in-place vertical scaling (not available at this release), so
+		// unlike the annotation-based restart test above there is no mutable
+		// input to re-patch. Instead, force a restart the same way that test
+		// forces its own restart bookkeeping: fail the liveness probe and let
+		// waitForPodContainerRestart drive the restart, then confirm the
+		// subpath mount captured at first mount is still the one in effect.
+		pod.Spec.Containers[0].LivenessProbe = &v1.Probe{
+			Handler: v1.Handler{
+				Exec: &v1.ExecAction{
+					Command: []string{"cat", "/subpath_mount/test.log"},
+				},
+			},
+			InitialDelaySeconds: 1,
+			FailureThreshold:    1,
+			PeriodSeconds:       2,
+		}
+
+		ginkgo.By("creating the pod")
+		var podClient *framework.PodClient = f.PodClient()
+		pod = podClient.Create(pod)
+		defer func() {
+			e2epod.DeletePodWithWait(f.ClientSet, pod)
+		}()
+
+		ginkgo.By("waiting for pod running")
+		err := e2epod.WaitForPodRunningInNamespace(f.ClientSet, pod)
+		framework.ExpectNoError(err, "while waiting for pod to be running")
+
+		pod, err = f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err, "while getting pod to read its IP")
+
+		resolvedDir := fmt.Sprintf("/volume_mount/100/%s", pod.Status.PodIP)
+		ginkgo.By("verifying the subpath was mounted at /<cpu-limit>/<pod-ip>")
+		cmd := fmt.Sprintf("test -d %s", resolvedDir)
+		_, _, err = f.ExecShellInPodWithFullOutput(pod.Name, cmd)
+		if err != nil {
+			framework.Failf("expected subpath to have been resolved from the resource limit and pod IP")
+		}
+
+		ginkgo.By("writing the liveness probe's target file through the resolved subpath")
+		_, _, err = f.ExecShellInPodWithFullOutput(pod.Name, "touch /subpath_mount/test.log")
+		framework.ExpectNoError(err, "while writing to the resolved subpath")
+
+		ginkgo.By("restarting the container")
+		waitForPodContainerRestart(f, pod, "/subpath_mount/test.log")
+
+		ginkgo.By("verifying the subpath mount is still resolved to the same resource-derived path after restart")
+		cmd = fmt.Sprintf("test -d %s", resolvedDir)
+		_, _, err = f.ExecShellInPodWithFullOutput(pod.Name, cmd)
+		if err != nil {
+			framework.Failf("expected subpath resolved at first mount to remain stable across restarts")
+		}
+	})
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, default value expansion
+		Description: Create a Pod with an environment variable that references an
+		undefined variable using the $(VAR:-default) syntax. The undefined
+		reference MUST expand to the given default, including when the default
+		itself nests another $(VAR:-default) expression, and MUST NOT leave the
+		$(VAR) token in the output.
+	*/
+	framework.ConformanceIt("should allow expanding a default value for an undefined variable [NodeConformance]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name:  "FOO",
+				Value: "foo-value",
+			},
+			{
+				Name:  "BAR_WITH_DEFAULT",
+				Value: "$(UNDEFINED:-bar-default)",
+			},
+			{
+				Name:  "NESTED_DEFAULT",
+				Value: "$(UNDEFINED_A:-$(UNDEFINED_B:-nested-default))",
+			},
+			{
+				Name:  "ALT_WHEN_SET",
+				Value: "$(FOO:+has-foo)",
+			},
+		}
+		pod := newPod([]string{"sh", "-c", "env"}, envVars, nil, nil)
+
+		f.TestContainerOutput("default value expansion", pod, 0, []string{
+			"FOO=foo-value",
+			"BAR_WITH_DEFAULT=bar-default",
+			"NESTED_DEFAULT=nested-default",
+			"ALT_WHEN_SET=has-foo",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, escaped expansion
+		Description: Create a Pod with an environment variable using the
+		$$(VAR) escape syntax. The escaped reference MUST expand to the literal
+		string $(VAR) rather than being substituted.
+	*/
+	framework.ConformanceIt("should allow escaping the $(VAR) expansion syntax [NodeConformance]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name:  "FOO",
+				Value: "foo-value",
+			},
+			{
+				Name:  "ESCAPED",
+				Value: "$$(FOO)",
+			},
+		}
+		pod := newPod([]string{"sh", "-c", "env"}, envVars, nil, nil)
+
+		f.TestContainerOutput("escaped expansion", pod, 0, []string{
+			"FOO=foo-value",
+			"ESCAPED=$(FOO)",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, command and args default value expansion
+		Description: Create a Pod whose container command and args reference an
+		undefined variable using the $(VAR:-default) syntax. Both MUST expand to
+		the given default.
+	*/
+	framework.ConformanceIt("should allow expanding default values in a container's command and args [NodeConformance]", func() {
+		pod := newPod([]string{"sh", "-c", "echo \"$(UNDEFINED:-default-in-command)\""}, nil, nil, nil)
+
+		f.TestContainerOutput("default value expansion in command", pod, 0, []string{
+			"default-in-command",
+		})
+
+		argsPod := newPod([]string{"sh", "-c"}, nil, nil, nil)
+		argsPod.Spec.Containers[0].Args = []string{"echo \"$(UNDEFINED:-default-in-args)\""}
+
+		f.TestContainerOutput("default value expansion in args", argsPod, 0, []string{
+			"default-in-args",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: VolumeSubpathEnvExpansion, subpath default value expansion
+		Description: Create a Pod whose SubPathExpr references an undefined
+		variable using the $(VAR:-default) syntax. The subpath MUST be mounted
+		using the expanded default.
+	*/
+	framework.ConformanceIt("should allow expanding a default value for an undefined variable in a volume subpath [sig-storage]", func() {
+		mounts := []v1.VolumeMount{
+			{
+				Name:        "workdir1",
+				MountPath:   "/logscontainer",
+				SubPathExpr: "$(UNDEFINED_POD_NAME:-defaultsubpath)",
+			},
+			{
+				Name:      "workdir1",
+				MountPath: "/testcontainer",
+			},
+		}
+		volumes := []v1.Volume{
+			{
+				Name: "workdir1",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		pod := newPod([]string{}, nil, mounts, volumes)
+		pod.Spec.Containers[0].Command = []string{"sh", "-c", "test -d /testcontainer/defaultsubpath;echo $?"}
+
+		f.TestContainerOutput("default value expansion in volume subpath", pod, 0, []string{
+			"0",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, command and args alt value expansion
+		Description: Create a Pod whose container command and args reference a
+		set, non-empty variable using the $(VAR:+alt) syntax. Both MUST expand
+		to the given alt value rather than the variable's own value.
+	*/
+	framework.ConformanceIt("should allow expanding an alt value in a container's command and args [NodeConformance]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name:  "FOO",
+				Value: "foo-value",
+			},
+		}
+		pod := newPod([]string{"sh", "-c", "echo \"$(FOO:+alt-in-command)\""}, envVars, nil, nil)
+
+		f.TestContainerOutput("alt value expansion in command", pod, 0, []string{
+			"alt-in-command",
+		})
+
+		argsPod := newPod([]string{"sh", "-c"}, envVars, nil, nil)
+		argsPod.Spec.Containers[0].Args = []string{"echo \"$(FOO:+alt-in-args)\""}
+
+		f.TestContainerOutput("alt value expansion in args", argsPod, 0, []string{
+			"alt-in-args",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: VolumeSubpathEnvExpansion, subpath alt value expansion
+		Description: Create a Pod whose SubPathExpr references a set,
+		non-empty variable using the $(VAR:+alt) syntax. The subpath MUST be
+		mounted using the expanded alt value.
+	*/
+	framework.ConformanceIt("should allow expanding an alt value in a volume subpath [sig-storage]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name:  "POD_NAME",
+				Value: "foo",
+			},
+		}
+		mounts := []v1.VolumeMount{
+			{
+				Name:        "workdir1",
+				MountPath:   "/logscontainer",
+				SubPathExpr: "$(POD_NAME:+altsubpath)",
+			},
+			{
+				Name:      "workdir1",
+				MountPath: "/testcontainer",
+			},
+		}
+		volumes := []v1.Volume{
+			{
+				Name: "workdir1",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		pod := newPod([]string{}, envVars, mounts, volumes)
+		pod.Spec.Containers[0].Command = []string{"sh", "-c", "test -d /testcontainer/altsubpath;echo $?"}
+
+		f.TestContainerOutput("alt value expansion in volume subpath", pod, 0, []string{
+			"0",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, command and args escaped expansion
+		Description: Create a Pod whose container command and args use the
+		$$(VAR) escape syntax. Both MUST expand to the literal string $(VAR)
+		rather than being substituted.
+	*/
+	framework.ConformanceIt("should allow escaping the $(VAR) expansion syntax in a container's command and args [NodeConformance]", func() {
+		// The kubelet turns $$(FOO) into the literal $(FOO) before the shell
+		// ever sees it, but a POSIX shell still performs its own command
+		// substitution on unescaped $(...) inside double quotes. Escape the
+		// shell's own interpretation too (\$(FOO), i.e. "\$$(FOO)" before
+		// kubelet expansion) so the asserted stdout reflects the kubelet's
+		// expansion rather than the shell trying to run "FOO" as a command.
+		pod := newPod([]string{"sh", "-c", "echo \"\\$$(FOO)\""}, nil, nil, nil)
+
+		f.TestContainerOutput("escaped expansion in command", pod, 0, []string{
+			"$(FOO)",
+		})
+
+		argsPod := newPod([]string{"sh", "-c"}, nil, nil, nil)
+		argsPod.Spec.Containers[0].Args = []string{"echo \"\\$$(FOO)\""}
+
+		f.TestContainerOutput("escaped expansion in args", argsPod, 0, []string{
+			"$(FOO)",
+		})
+	})
+
+	/*
+		Release : v1.21
+		Testname: VolumeSubpathEnvExpansion, subpath escaped expansion
+		Description: Create a Pod whose SubPathExpr uses the $$(VAR) escape
+		syntax. The subpath MUST be mounted using the literal, un-substituted
+		string rather than an expanded variable value.
+	*/
+	framework.ConformanceIt("should allow escaping the $(VAR) expansion syntax in a volume subpath [sig-storage]", func() {
+		envVars := []v1.EnvVar{
+			{
+				Name:  "POD_NAME",
+				Value: "foo",
+			},
+		}
+		mounts := []v1.VolumeMount{
+			{
+				Name:        "workdir1",
+				MountPath:   "/logscontainer",
+				SubPathExpr: "$$(POD_NAME)",
+			},
+			{
+				Name:      "workdir1",
+				MountPath: "/testcontainer",
+			},
+		}
+		volumes := []v1.Volume{
+			{
+				Name: "workdir1",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		pod := newPod([]string{}, envVars, mounts, volumes)
+		// Command strings go through the same kubelet-side expansion as
+		// SubPathExpr (pkg/kubelet/container/helpers.go), and POD_NAME is a
+		// real, set env var on this pod. An unescaped $(POD_NAME) here would
+		// be expanded by the kubelet to "foo" before the shell ever runs it,
+		// checking the wrong path. Escape it so it reaches the shell as the
+		// literal "$(POD_NAME)" that matches the subpath actually mounted.
+		pod.Spec.Containers[0].Command = []string{"sh", "-c", "test -d '/testcontainer/$$(POD_NAME)';echo $?"}
+
+		f.TestContainerOutput("escaped expansion in volume subpath", pod, 0, []string{
+			"0",
+		})
+	})
+
+	/*
+		Release : v1.19
+		Testname: VolumeSubpathEnvExpansion, subpath expansion disabled
+		Description: When the VolumeSubpathEnvExpansion feature gate is disabled, a Pod
+		specifying SubPathExpr MUST be rejected by the API server with a Forbidden field
+		error rather than admitted and silently left unexpanded.
+	*/
+	ginkgo.It("should reject a SubPathExpr when the VolumeSubpathEnvExpansion feature gate is disabled [sig-storage]", func() {
+		if featureGateEnabled(f, subpathEnvExpansionGate) {
+			e2eskipper.Skipf("%s feature gate is enabled on the apiserver", subpathEnvExpansionGate)
+		}
+
+		envVars := []v1.EnvVar{
+			{
+				Name:  "POD_NAME",
+				Value: "foo",
+			},
+		}
+		mounts := []v1.VolumeMount{
+			{
+				Name:        "workdir1",
+				MountPath:   "/logscontainer",
+				SubPathExpr: "$(POD_NAME)",
+			},
+		}
+		volumes := []v1.Volume{
+			{
+				Name: "workdir1",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		pod := newPod(nil, envVars, mounts, volumes)
+
+		ginkgo.By("creating the pod and expecting a Forbidden admission error")
+		_, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Create(context.TODO(), pod, metav1.CreateOptions{})
+		framework.ExpectError(err, "expected pod creation to be rejected")
+		// Field validation errors come back as an Invalid status whose
+		// causes carry the field error type.
+		forbiddenField := false
+		if status, ok := err.(apierrors.APIStatus); ok && status.Status().Details != nil {
+			for _, cause := range status.Status().Details.Causes {
+				if cause.Type == metav1.CauseTypeFieldValueForbidden {
+					forbiddenField = true
+				}
+			}
+		}
+		if !apierrors.IsInvalid(err) || !forbiddenField {
+			framework.Failf("expected a Forbidden field error for subPathExpr, got: %v", err)
+		}
+	})
+
+	/*
+		Release : v1.19
+		Testname: EnvVarExpansion, expansion disabled
+		Description: When the EnvVarExpansion feature gate is disabled, the literal
+		$(VAR) token MUST be passed through to the container unexpanded.
+	*/
+	ginkgo.It("should pass through $(VAR) literally when the EnvVarExpansion feature gate is disabled [NodeConformance]", func() {
+		if featureGateEnabled(f, envVarExpansionGate) {
+			e2eskipper.Skipf("%s feature gate is enabled on the apiserver", envVarExpansionGate)
+		}
+
+		envVars := []v1.EnvVar{
+			{
+				Name:  "FOO",
+				Value: "foo-value",
+			},
+		}
+		// Single quotes keep the shell from running $(FOO) as a command
+		// substitution, so stdout shows whatever the kubelet passed through.
+		pod := newPod([]string{"sh", "-c", "echo '$(FOO)'"}, envVars, nil, nil)
+
+		f.TestContainerOutput("no expansion when disabled", pod, 0, []string{
+			"$(FOO)",
+		})
+	})
+})
+
+// featureGateEnabled reports whether the named feature gate is currently
+// enabled on the API server, as exposed by the apiserver's /metrics endpoint
+// via the kubernetes_feature_enabled gauge. Tests that need to assert
+// different behavior depending on a gate's state should branch on this
+// instead of assuming a fixed default, so the suite stays green regardless of
+// how the cluster under test was configured.
+//
+// Both gates checked by the callers above are GA in releases at or after the
+// ones these tests target, so on most clusters featureGateEnabled returns
+// true and the disabled-path assertions above are skipped via
+// e2eskipper.Skipf rather than run against a state the cluster doesn't have.
+// That is still the correct shape for this coverage: a cluster that does run
+// with the gate forced off (e.g. an explicit --feature-gates=...=false
+// override during a gate's GA-deprecation window) gets a real, executed
+// assertion instead of permanently-skipped dead weight.
+func featureGateEnabled(f *framework.Framework, gate string) bool {
+	data, err := f.ClientSet.Discovery().RESTClient().Get().AbsPath("metrics").DoRaw(context.TODO())
+	framework.ExpectNoError(err, "fetching apiserver /metrics")
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	framework.ExpectNoError(err, "parsing apiserver /metrics")
+
+	family, ok := families["kubernetes_feature_enabled"]
+	if !ok {
+		framework.Failf("apiserver /metrics did not publish a kubernetes_feature_enabled gauge; cannot determine %s gate state", gate)
+	}
+
+	for _, metric := range family.GetMetric() {
+		var name string
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "name" {
+				name = label.GetValue()
+			}
+		}
+		if name == gate {
+			return metric.GetGauge().GetValue() == 1
+		}
+	}
+
+	framework.Failf("apiserver /metrics did not report a kubernetes_feature_enabled series for gate %s", gate)
+	return false
+}
+
+// This suite covers expansion that crosses container boundaries within a
+// single pod: a main container and a sidecar, each resolving their own
+// EnvVarSource entries independently, with the sidecar also referencing a
+// name that is only ever defined on the main container. Each container's
+// $(VAR) expansion is scoped to its own Env list, whatever order the
+// containers start in, so nothing threads live values between sibling
+// containers, and each
+// container's own EnvVarSource fields (e.g. a FieldRef into annotations) are
+// resolved independently of whatever the other container computed. Forward
+// references therefore fall through to expansion's existing
+// unresolved-reference behavior (the literal $(VAR) token is left in place).
+//
+// The originating request also asked for (a) admission-time rejection of
+// circular cross-container references and (b) a new
+// status.containerStatuses[].expansionWarnings field surfacing un-expanded
+// forward references. Neither exists in this checkout: there is no
+// cross-container reference graph built anywhere in admission to detect a
+// cycle against, and ExpansionWarnings is not a field on ContainerStatus in
+// this version of the API. Both are out of scope for this change and are
+// not covered below; they should be tracked as follow-up work rather than
+// assumed to be implemented here.
+//
+// What ships is also narrower than the request's "generate from template"
+// shape: a single env forward-reference between a main container and a
+// sidecar, with no init container in the pod and no cross-reference
+// exercised through args or subPathExpr.
+var _ = framework.KubeDescribe("Variable Expansion Across Containers", func() {
+	f := framework.NewDefaultFramework("var-expansion-cross-container")
+
+	/*
+		Release : v1.21
+		Testname: Environment variables, cross-container forward reference
+		Description: A pod with a main container and a sidecar, where the
+		sidecar's own env references a name that is only defined on the main
+		container. Because containers do not share an expansion context, the
+		reference MUST be left un-expanded (the literal $(VAR) token) in the
+		sidecar's environment, even though the sidecar's own FieldRef-derived
+		env var MUST still resolve normally.
+	*/
+	framework.ConformanceIt("should leave forward references to another container's computed value unexpanded [NodeConformance]", func() {
+		pod := newMultiContainerPod(TemplateSpec{
+			Container: ContainerTemplate{
+				Name:    "main",
+				Command: []string{"sh", "-c", "env"},
+				EnvVars: []v1.EnvVar{
+					{Name: "SUBPATH", Value: "computed-by-main"},
+				},
+			},
+			Sidecar: &ContainerTemplate{
+				Name:    "sidecar",
+				Command: []string{"sh", "-c", "env"},
+				EnvVars: []v1.EnvVar{
+					{
+						Name: "ANNOTATION",
+						ValueFrom: &v1.EnvVarSource{
+							FieldRef: &v1.ObjectFieldSelector{
+								FieldPath: "metadata.annotations['sidecar-input']",
+							},
+						},
+					},
+					// SUBPATH is only ever defined on the main container, so
+					// this is a forward reference the sidecar cannot resolve.
+					{Name: "SIDECAR_ARG", Value: "$(SUBPATH)"},
+				},
+			},
+		})
+		pod.ObjectMeta.Annotations = map[string]string{"sidecar-input": "sidecar-value"}
+
+		f.TestContainerOutput("forward reference left unexpanded, own field ref still resolves", pod, 1, []string{
+			"ANNOTATION=sidecar-value",
+			"SIDECAR_ARG=$(SUBPATH)",
+		})
+	})
 })
 
 func testPodFailSubpath(f *framework.Framework, pod *v1.Pod) {
@@ -546,19 +1138,64 @@ func waitForPodContainerRestart(f *framework.Framework, pod *v1.Pod, volumeMount
 	framework.ExpectNoError(err, "while waiting for container to stabilize")
 }
 
-func newPod(command []string, envVars []v1.EnvVar, mounts []v1.VolumeMount, volumes []v1.Volume) *v1.Pod {
+// ContainerTemplate describes one container (main or sidecar) to
+// generate for a TemplateSpec.
+type ContainerTemplate struct {
+	Name    string
+	Command []string
+	EnvVars []v1.EnvVar
+	Mounts  []v1.VolumeMount
+}
+
+func (c ContainerTemplate) nameOrDefault(def string) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return def
+}
+
+// TemplateSpec describes the containers to generate for a pod used in the
+// expansion tests: a required main container plus an optional sidecar, so
+// tests can exercise expansion that crosses container boundaries.
+type TemplateSpec struct {
+	Container ContainerTemplate
+	Sidecar   *ContainerTemplate
+	Volumes   []v1.Volume
+}
+
+// newMultiContainerPod builds a Pod from a TemplateSpec. It supersedes the
+// single-container newPod below, which is now a thin wrapper over it for the
+// many tests above that only need one container.
+func newMultiContainerPod(spec TemplateSpec) *v1.Pod {
 	podName := "var-expansion-" + string(uuid.NewUUID())
-	return &v1.Pod{
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   podName,
 			Labels: map[string]string{"name": podName},
 		},
 		Spec: v1.PodSpec{
-			Containers:    []v1.Container{newContainer("dapi-container", command, envVars, mounts)},
+			Containers:    []v1.Container{newContainer(spec.Container.nameOrDefault("dapi-container"), spec.Container.Command, spec.Container.EnvVars, spec.Container.Mounts)},
 			RestartPolicy: v1.RestartPolicyNever,
-			Volumes:       volumes,
+			Volumes:       spec.Volumes,
 		},
 	}
+	if spec.Sidecar != nil {
+		sidecar := newContainer(spec.Sidecar.nameOrDefault("sidecar-container"), spec.Sidecar.Command, spec.Sidecar.EnvVars, spec.Sidecar.Mounts)
+		pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
+	}
+
+	return pod
+}
+
+func newPod(command []string, envVars []v1.EnvVar, mounts []v1.VolumeMount, volumes []v1.Volume) *v1.Pod {
+	return newMultiContainerPod(TemplateSpec{
+		Container: ContainerTemplate{
+			Command: command,
+			EnvVars: envVars,
+			Mounts:  mounts,
+		},
+		Volumes: volumes,
+	})
 }
 
 func newContainer(containerName string, command []string, envVars []v1.EnvVar, mounts []v1.VolumeMount) v1.Container {
@@ -0,0 +1,191 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expansion
+
+import (
+	"bytes"
+)
+
+const (
+	operator        = '$'
+	referenceOpener = '('
+	referenceCloser = ')'
+
+	// maxExpansionDepth bounds how many times a default ("$(VAR:-default)")
+	// or alternate ("$(VAR:+alt)") clause may itself be expanded when it
+	// contains nested references. Without a bound, a malicious or mistaken
+	// input like "$(A:-$(A:-$(A:-...)))" could force unbounded recursion.
+	maxExpansionDepth = 10
+)
+
+// syntaxWrap returns the input string wrapped by the expansion syntax.
+func syntaxWrap(input string) string {
+	return string(operator) + string(referenceOpener) + input + string(referenceCloser)
+}
+
+// MappingFuncFor returns a mapping function for use with Expand that looks up
+// the value of a variable name in the given context maps, in order, and
+// reports whether it was found. This is the expansion-library analog of
+// os.LookupEnv: callers that only care about the value, not whether it was
+// actually set, can ignore the second return.
+func MappingFuncFor(context ...map[string]string) func(string) (string, bool) {
+	return func(input string) (string, bool) {
+		for _, vars := range context {
+			val, ok := vars[input]
+			if ok {
+				return val, true
+			}
+		}
+
+		return "", false
+	}
+}
+
+// Expand replaces variable references in the input string according to the
+// expansion spec using the given mapping function to resolve the values of
+// variables. It understands:
+//
+//   - $(VAR)          plain substitution; left unexpanded (verbatim) if VAR is unset
+//   - $(VAR:-default) use default if VAR is unset or empty
+//   - $(VAR:+alt)     use alt if VAR is set and non-empty, otherwise empty
+//   - $$(VAR)         literal escape; expands to $(VAR) without substitution
+//
+// default and alt may themselves contain nested $(...) references, which are
+// expanded using the same mapping function, up to a fixed recursion depth.
+func Expand(input string, mapping func(string) (string, bool)) string {
+	return expand(input, mapping, 0)
+}
+
+func expand(input string, mapping func(string) (string, bool), depth int) string {
+	var buf bytes.Buffer
+	checkpoint := 0
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] == operator && cursor+1 < len(input) {
+			// Copy the portion of the input string since the last
+			// checkpoint into the buffer.
+			buf.WriteString(input[checkpoint:cursor])
+
+			// Attempt to read the variable expression as defined by the
+			// syntax from the input string.
+			read, isVar, advance := tryReadVariableExpr(input[cursor+1:])
+
+			if isVar {
+				buf.WriteString(resolve(read, mapping, depth))
+			} else {
+				// Not a variable expression; copy the read bytes verbatim.
+				buf.WriteString(read)
+			}
+
+			// Advance the cursor to account for bytes consumed reading the
+			// variable expression, and move the checkpoint past it.
+			cursor += advance
+			checkpoint = cursor + 1
+		}
+	}
+
+	// Return the buffer plus any remaining unwritten bytes in the input.
+	return buf.String() + input[checkpoint:]
+}
+
+// tryReadVariableExpr attempts to read a variable expression (the contents of
+// a $(...) reference, including any :- or :+ modifier) from the input
+// string. It returns the content read, whether that content is a variable
+// expression to resolve, and the number of bytes consumed from input.
+//
+// input is assumed not to contain the initial operator rune.
+func tryReadVariableExpr(input string) (string, bool, int) {
+	switch input[0] {
+	case operator:
+		// Escaped operator; return it literally.
+		return input[0:1], false, 1
+	case referenceOpener:
+		// Scan to the matching closer, tracking nested $(...) references so
+		// that default/alt clauses containing their own references (e.g.
+		// $(A:-$(B:-fallback))) are read in full.
+		depth := 0
+		for i := 1; i < len(input); i++ {
+			switch input[i] {
+			case referenceOpener:
+				depth++
+			case referenceCloser:
+				if depth == 0 {
+					return input[1:i], true, i + 1
+				}
+				depth--
+			}
+		}
+
+		// Incomplete reference; return the opening bytes verbatim.
+		return string(operator) + string(referenceOpener), false, 1
+	default:
+		// Not the beginning of an expression, i.e. an operator that doesn't
+		// immediately precede a reference opener.
+		return string(operator), false, 0
+	}
+}
+
+// resolve expands a single reference's contents (the text between the
+// parentheses of a $(...) expression), applying the :- (default) and :+
+// (alternate) modifiers when present.
+func resolve(expr string, mapping func(string) (string, bool), depth int) string {
+	name, op, clause, hasModifier := splitModifier(expr)
+
+	val, isSet := mapping(name)
+	if !hasModifier {
+		if isSet {
+			return val
+		}
+		return syntaxWrap(name)
+	}
+
+	if depth >= maxExpansionDepth {
+		// Refuse to expand the clause any further; surface it verbatim so
+		// the result is visibly truncated rather than the process hanging.
+		return clause
+	}
+
+	if op == ":-" {
+		if isSet && val != "" {
+			return val
+		}
+		return expand(clause, mapping, depth+1)
+	}
+
+	// op == ":+"; splitModifier never returns hasModifier=true for anything else.
+	if isSet && val != "" {
+		return expand(clause, mapping, depth+1)
+	}
+	return ""
+}
+
+// splitModifier splits a reference's inner expression into the variable
+// name, the modifier operator (":-" or ":+"), and the clause that follows
+// it. The modifier is only recognized immediately after the variable name,
+// so "FOO:-bar" splits into ("FOO", ":-", "bar", true) while "FOO" alone
+// reports hasModifier=false.
+func splitModifier(expr string) (name, op, clause string, hasModifier bool) {
+	for i := 0; i+1 < len(expr); i++ {
+		if expr[i] != ':' {
+			continue
+		}
+		switch expr[i+1] {
+		case '-', '+':
+			return expr[:i], expr[i : i+2], expr[i+2:], true
+		}
+	}
+	return expr, "", "", false
+}
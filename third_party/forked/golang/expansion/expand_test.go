@@ -0,0 +1,155 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expansion
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMapReference(t *testing.T) {
+	context := map[string]string{
+		"VAR_A":     "A",
+		"VAR_B":     "B",
+		"VAR_EMPTY": "",
+	}
+	mapping := MappingFuncFor(context)
+
+	doExpansionTest(t, mapping)
+}
+
+func doExpansionTest(t *testing.T, mapping func(string) (string, bool)) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "normal expansion",
+			input:    "$(VAR_A)",
+			expected: "A",
+		},
+		{
+			name:     "compound expansion",
+			input:    "foo $(VAR_A) bar $(VAR_B) baz",
+			expected: "foo A bar B baz",
+		},
+		{
+			name:     "unset variable is left unexpanded",
+			input:    "$(VAR_C)",
+			expected: "$(VAR_C)",
+		},
+		{
+			name:     "literal escape",
+			input:    "$$(VAR_A)",
+			expected: "$(VAR_A)",
+		},
+		{
+			name:     "trailing dollar sign",
+			input:    "VAR_A is $",
+			expected: "VAR_A is $",
+		},
+		{
+			name:     "unterminated reference is left unexpanded",
+			input:    "$(VAR_A",
+			expected: "$(VAR_A",
+		},
+		{
+			name:     "default value used when unset",
+			input:    "$(VAR_C:-default)",
+			expected: "default",
+		},
+		{
+			name:     "default value ignored when set",
+			input:    "$(VAR_A:-default)",
+			expected: "A",
+		},
+		{
+			name:     "default value used when set but empty",
+			input:    "$(VAR_EMPTY:-default)",
+			expected: "default",
+		},
+		{
+			name:     "alternate value used when set",
+			input:    "$(VAR_A:+alt)",
+			expected: "alt",
+		},
+		{
+			name:     "alternate value ignored when unset",
+			input:    "$(VAR_C:+alt)",
+			expected: "",
+		},
+		{
+			name:     "nested default falls through to inner default",
+			input:    "$(VAR_C:-$(VAR_D:-fallback))",
+			expected: "fallback",
+		},
+		{
+			name:     "nested default resolves to inner variable",
+			input:    "$(VAR_C:-$(VAR_A))",
+			expected: "A",
+		},
+		{
+			name:     "malformed modifier left unexpanded",
+			input:    "$(VAR_A:?default)",
+			expected: "$(VAR_A:?default)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expanded := Expand(tc.input, mapping)
+			if expanded != tc.expected {
+				t.Errorf("Expand(%q) = %q, want %q", tc.input, expanded, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExpansionRecursionDepthIsBounded(t *testing.T) {
+	// An expansion "bomb": each default clause re-references an unset
+	// variable whose own default is another layer of the same shape. With
+	// the bound, expansion stops after maxExpansionDepth+1 layers and the
+	// remaining layers are returned verbatim; without it the result would
+	// be "leaf".
+	var bomb strings.Builder
+	const layers = 1000
+	for i := 0; i < layers; i++ {
+		bomb.WriteString("$(VAR_UNSET:-")
+	}
+	bomb.WriteString("leaf")
+	for i := 0; i < layers; i++ {
+		bomb.WriteString(")")
+	}
+
+	mapping := MappingFuncFor(map[string]string{})
+
+	done := make(chan string, 1)
+	go func() { done <- Expand(bomb.String(), mapping) }()
+
+	select {
+	case result := <-done:
+		remaining := layers - maxExpansionDepth - 1
+		want := strings.Repeat("$(VAR_UNSET:-", remaining) + "leaf" + strings.Repeat(")", remaining)
+		if result != want {
+			t.Errorf("expected expansion to stop after %d layers, got a result of length %d, want length %d", maxExpansionDepth+1, len(result), len(want))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expand did not return; recursion depth is not bounded")
+	}
+}